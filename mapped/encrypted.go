@@ -0,0 +1,404 @@
+package mapped
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	crand "crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// encryptedBlockSize is the fixed plaintext block size sealed by
+// EncryptedFile. Writes must be block-aligned or they trigger a
+// read-modify-write of the containing block.
+const encryptedBlockSize = 4096
+
+// fileIDHeaderSize is the width of the random fileID persisted at the very
+// start of the sidecar header. A 64-bit ID drawn from crypto/rand at
+// creation keeps fileIDs collision-resistant across however many files
+// share a key; deriving it from the file name instead (as an FNV-32 hash)
+// would let two differently-named files collide in that 32-bit space and
+// reuse the exact same (fileID, version) nonce under the same key.
+const fileIDHeaderSize = 8
+
+var (
+	// ErrAuthFailed is returned when a block's authentication tag does not
+	// verify on decryption.
+	ErrAuthFailed = errors.New("mapped: block authentication failed")
+
+	errBadKeySize = errors.New("mapped: key must be 32 bytes for AES-256-GCM")
+
+	// errEncryptedUnsupported is returned by EncryptedFile methods that
+	// take plaintext-space offsets/sizes but whose promoted *File
+	// implementation would apply them to the physical (header+ciphertext)
+	// layout instead, silently corrupting or leaking data.
+	errEncryptedUnsupported = errors.New("mapped: operation not supported on EncryptedFile; use Read/Write")
+)
+
+// sealHeaderEntrySize is the per-block sidecar header entry: a 4-byte
+// version counter followed by the AEAD tag. The version is re-read on
+// every decrypt and bumped on every re-seal of the block so that the
+// nonce derived from (fileID, version) is never reused for two different
+// plaintexts under the same key, even when repeated small/unaligned
+// Writes land in the same block (see nonceForVersion).
+func (ef *EncryptedFile) sealHeaderEntrySize() int64 {
+	return 4 + int64(ef.aead.Overhead())
+}
+
+// EncryptedFile is a block-encrypted drop-in variant of File. Each
+// encryptedBlockSize-byte plaintext block is sealed independently with
+// AES-256-GCM, using a nonce derived from (fileID, version), where version
+// is a per-file monotonic counter bumped on every seal so a block can be
+// re-encrypted (e.g. by a read-modify-write) without ever reusing a nonce.
+// The version and tag for each block live in a sidecar header region at
+// the start of the mmap, ahead of the ciphertext blocks. Reads decrypt
+// into the caller's buffer rather than the mmap itself, so plaintext
+// never sits in the mapping.
+//
+// Read, ReadRLocked and Write work on the logical plaintext; Close, Remove,
+// Sync and LastModified act on the whole physical file. WriteAt,
+// WriteBuffers, ReadAtv, PunchHole, Zero, Allocate, Resize and Shrink are
+// overridden below to return errEncryptedUnsupported instead of
+// corrupting or leaking data. The other methods promoted from *File (such
+// as IsFull, MappedBytes, SeekData and SeekHole) are not overridden and
+// operate on the physical header+ciphertext layout, not the plaintext one.
+type EncryptedFile struct {
+	*File
+
+	aead        cipher.AEAD
+	fileID      uint64
+	headerSize  int64  // bytes reserved at the start of the mmap for the header
+	blocks      int64  // number of encryptedBlockSize plaintext blocks
+	nextVersion uint32 // monotonic counter handed out to the next seal of any block
+}
+
+// OpenEncrypted opens fileName as a block-encrypted mapped.File. fileSize
+// is the logical plaintext capacity; the underlying File is sized to also
+// hold the per-block tag header.
+func OpenEncrypted(fileName string, fileSize int64, flags int, key []byte, wmm bool, pool *sync.Pool) (ef *EncryptedFile, err error) {
+	if len(key) != 32 {
+		err = errBadKeySize
+		return
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return
+	}
+
+	entrySize := int64(4 + aead.Overhead())
+	blocks := (fileSize + encryptedBlockSize - 1) / encryptedBlockSize
+	headerSize := fileIDHeaderSize + blocks*entrySize
+	// round the header up to a block boundary so data block offsets stay
+	// aligned to encryptedBlockSize.
+	headerBlocks := (headerSize + encryptedBlockSize - 1) / encryptedBlockSize
+	physicalSize := headerBlocks*encryptedBlockSize + blocks*encryptedBlockSize
+
+	f, err := OpenFile(fileName, physicalSize, flags, wmm, pool)
+	if err != nil {
+		return
+	}
+
+	ef = &EncryptedFile{
+		File:       f,
+		aead:       aead,
+		headerSize: headerBlocks * encryptedBlockSize,
+		blocks:     blocks,
+	}
+	if err = ef.initFileID(); err != nil {
+		return
+	}
+	ef.initVersionCounter()
+	return
+}
+
+// initFileID reads the random fileID persisted at the very start of the
+// sidecar header, or draws a fresh one from crypto/rand and persists it if
+// nothing has been written yet (a brand new, or still-empty, file). The ID
+// is collision-resistant and independent of fileName, unlike a hash of the
+// path: two files sharing a key must never end up with the same fileID, or
+// their first-ever seals would reuse the exact same (fileID, version) nonce.
+func (ef *EncryptedFile) initFileID() error {
+	buf := make([]byte, fileIDHeaderSize)
+	if ef.wmm {
+		copy(buf, ef.MappedBytes()[:fileIDHeaderSize])
+	} else {
+		_, _ = ef.file.ReadAt(buf, 0)
+	}
+
+	if ef.GetWrotePosition() == 0 {
+		if _, err := crand.Read(buf); err != nil {
+			return err
+		}
+		if ef.wmm {
+			copy(ef.MappedBytes()[:fileIDHeaderSize], buf)
+		} else if _, err := ef.file.WriteAt(buf, 0); err != nil {
+			return err
+		}
+	}
+
+	ef.fileID = binary.BigEndian.Uint64(buf)
+	return nil
+}
+
+// initVersionCounter scans the sidecar header of the blocks already
+// written and sets nextVersion past the highest version found, so a
+// reopened file never reissues a (fileID, version) nonce pair that was
+// already used before the process restarted. Like decryptBlock/
+// encryptBlock, it reads via ef.file rather than the mmap when !ef.wmm: in
+// non-wmm mode writes go through ef.file, not fmap, so fmap can hold stale
+// header bytes from whatever the file looked like at mmap time.
+func (ef *EncryptedFile) initVersionCounter() {
+	writtenBlocks := (ef.GetWrotePosition() + encryptedBlockSize - 1) / encryptedBlockSize
+	entrySize := ef.sealHeaderEntrySize()
+
+	header := make([]byte, writtenBlocks*entrySize)
+	if ef.wmm {
+		copy(header, ef.MappedBytes()[fileIDHeaderSize:fileIDHeaderSize+int64(len(header))])
+	} else {
+		_, _ = ef.file.ReadAt(header, fileIDHeaderSize)
+	}
+
+	var max uint32
+	for i := int64(0); i < writtenBlocks; i++ {
+		start := i * entrySize
+		if v := binary.BigEndian.Uint32(header[start : start+4]); v > max {
+			max = v
+		}
+	}
+	ef.nextVersion = max
+}
+
+// nonceForVersion derives the AEAD nonce for a seal from (fileID, version).
+// version must never repeat for this fileID, which is why it comes from
+// the monotonic nextVersion counter rather than the (static) block index.
+func (ef *EncryptedFile) nonceForVersion(version uint32) []byte {
+	nonce := make([]byte, ef.aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce[:8], ef.fileID)
+	binary.BigEndian.PutUint32(nonce[8:], version)
+	return nonce
+}
+
+func (ef *EncryptedFile) headerEntryOffset(blockIdx int64) int64 {
+	return fileIDHeaderSize + blockIdx*ef.sealHeaderEntrySize()
+}
+
+func (ef *EncryptedFile) blockDataOffset(blockIdx int64) int64 {
+	return ef.headerSize + blockIdx*encryptedBlockSize
+}
+
+// decryptBlock reads and authenticates blockIdx, returning freshly decrypted
+// plaintext (never a view into f.fmap). It reads the header entry and
+// ciphertext via f.file when !ef.wmm, the same split every other File
+// method makes: the mmap is only writable (and only the authoritative copy
+// of what was last written) when wmm is true, so reading it directly in
+// non-wmm mode can see stale or garbage bytes.
+func (ef *EncryptedFile) decryptBlock(blockIdx int64) (plain []byte, err error) {
+	tagSize := ef.aead.Overhead()
+	entryStart := ef.headerEntryOffset(blockIdx)
+	dataStart := ef.blockDataOffset(blockIdx)
+	entrySize := 4 + int64(tagSize)
+
+	var entry, cipherBlock []byte
+	if ef.wmm {
+		fmap := ef.MappedBytes()
+		entry = fmap[entryStart : entryStart+entrySize]
+		cipherBlock = fmap[dataStart : dataStart+encryptedBlockSize]
+	} else {
+		entry = make([]byte, entrySize)
+		if _, err = ef.file.ReadAt(entry, entryStart); err != nil {
+			return
+		}
+		cipherBlock = make([]byte, encryptedBlockSize)
+		if _, err = ef.file.ReadAt(cipherBlock, dataStart); err != nil {
+			return
+		}
+	}
+
+	version := binary.BigEndian.Uint32(entry[:4])
+	tag := entry[4 : 4+int64(tagSize)]
+
+	sealed := make([]byte, 0, encryptedBlockSize+tagSize)
+	sealed = append(sealed, cipherBlock...)
+	sealed = append(sealed, tag...)
+
+	plain, err = ef.aead.Open(sealed[:0], ef.nonceForVersion(version), sealed, nil)
+	if err != nil {
+		err = ErrAuthFailed
+	}
+	return
+}
+
+// encryptBlock seals plain (which must be encryptedBlockSize long) under a
+// freshly issued version and writes version, ciphertext and tag. Like
+// decryptBlock, it only touches the mmap directly when ef.wmm; otherwise it
+// writes through ef.file, which is how every other File method reaches
+// storage in non-wmm mode (the mmap there is mapped without PROT_WRITE).
+func (ef *EncryptedFile) encryptBlock(blockIdx int64, plain []byte) error {
+	version := atomic.AddUint32(&ef.nextVersion, 1)
+
+	sealed := ef.aead.Seal(nil, ef.nonceForVersion(version), plain, nil)
+	tagSize := ef.aead.Overhead()
+
+	entryStart := ef.headerEntryOffset(blockIdx)
+	dataStart := ef.blockDataOffset(blockIdx)
+
+	entry := make([]byte, 4+tagSize)
+	binary.BigEndian.PutUint32(entry[:4], version)
+	copy(entry[4:], sealed[len(sealed)-tagSize:])
+
+	if ef.wmm {
+		fmap := ef.MappedBytes()
+		copy(fmap[dataStart:dataStart+encryptedBlockSize], sealed[:len(sealed)-tagSize])
+		copy(fmap[entryStart:entryStart+int64(len(entry))], entry)
+		return nil
+	}
+
+	if _, err := ef.file.WriteAt(sealed[:len(sealed)-tagSize], dataStart); err != nil {
+		return err
+	}
+	if _, err := ef.file.WriteAt(entry, entryStart); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Read decrypts at most len(data) plaintext bytes starting at offset.
+func (ef *EncryptedFile) Read(offset int64, data []byte) (n int, err error) {
+	ef.RLock()
+	defer ef.RUnlock()
+	return ef.ReadRLocked(offset, data)
+}
+
+// ReadRLocked is Read for a caller already holding the File's read lock.
+func (ef *EncryptedFile) ReadRLocked(offset int64, data []byte) (n int, err error) {
+	readPosition := ef.GetWrotePosition()
+
+	for n < len(data) {
+		pos := offset + int64(n)
+		if pos >= readPosition {
+			break
+		}
+
+		blockIdx := pos / encryptedBlockSize
+		blockOff := pos % encryptedBlockSize
+
+		plain, decErr := ef.decryptBlock(blockIdx)
+		if decErr != nil {
+			err = decErr
+			return
+		}
+
+		c := copy(data[n:], plain[blockOff:])
+		n += c
+	}
+
+	return
+}
+
+// Write encrypts data in fixed blocks starting at the current wrote
+// position. A write that does not start on a block boundary, or that does
+// not fill a whole block, triggers a read-modify-write of the containing
+// block under cwmu so the rest of the block's plaintext is preserved.
+func (ef *EncryptedFile) Write(data []byte) (n int, err error) {
+	offset := ef.GetWrotePosition()
+	if offset+int64(len(data)) > ef.blocks*encryptedBlockSize {
+		err = ErrWriteBeyond
+		return
+	}
+
+	ef.cwmu.Lock()
+	defer ef.cwmu.Unlock()
+
+	for n < len(data) {
+		pos := offset + int64(n)
+		blockIdx := pos / encryptedBlockSize
+		blockOff := pos % encryptedBlockSize
+
+		toWrite := data[n:]
+		full := blockOff == 0 && int64(len(toWrite)) >= encryptedBlockSize
+
+		var plain []byte
+		if full {
+			plain = make([]byte, encryptedBlockSize)
+		} else if blockIdx*encryptedBlockSize < offset {
+			// block already holds committed plaintext: read-modify-write
+			plain, err = ef.decryptBlock(blockIdx)
+			if err != nil {
+				return
+			}
+		} else {
+			plain = make([]byte, encryptedBlockSize)
+		}
+
+		c := copy(plain[blockOff:], toWrite)
+		if err = ef.encryptBlock(blockIdx, plain); err != nil {
+			ef.addAndGetWrotePosition(int64(n))
+			return
+		}
+		n += c
+	}
+
+	ef.addAndGetWrotePosition(int64(n))
+	return
+}
+
+// Sync is safe to inherit in spirit but is spelled out explicitly here:
+// it flushes the whole physical mmap/file and does no plaintext-offset
+// math, unlike the methods guarded below.
+func (ef *EncryptedFile) Sync() error {
+	return ef.File.Sync()
+}
+
+// WriteAt is not supported: *File.WriteAt writes plaintext straight into
+// the physical header+ciphertext region at a plaintext-space offset,
+// which would corrupt the sidecar header and ciphertext blocks. Use Write.
+func (ef *EncryptedFile) WriteAt(data []byte, offset int64) (n int, err error) {
+	return 0, errEncryptedUnsupported
+}
+
+// WriteBuffers is not supported for the same reason as WriteAt. Use Write.
+func (ef *EncryptedFile) WriteBuffers(buffs *net.Buffers) (n int64, err error) {
+	return 0, errEncryptedUnsupported
+}
+
+// ReadAtv is not supported: *File.ReadAtv copies raw ciphertext bytes out
+// of the mmap without decrypting them. Use Read.
+func (ef *EncryptedFile) ReadAtv(offset int64, bufs [][]byte) (n int64, err error) {
+	return 0, errEncryptedUnsupported
+}
+
+// PunchHole is not supported: the plaintext-space range does not correspond
+// to the physical header+ciphertext layout.
+func (ef *EncryptedFile) PunchHole(offset, length int64) error {
+	return errEncryptedUnsupported
+}
+
+// Zero is not supported for the same reason as PunchHole.
+func (ef *EncryptedFile) Zero(offset, length int64) error {
+	return errEncryptedUnsupported
+}
+
+// Allocate is not supported for the same reason as PunchHole.
+func (ef *EncryptedFile) Allocate(offset, length int64) error {
+	return errEncryptedUnsupported
+}
+
+// Resize is not supported: the physical file size is derived from the
+// logical plaintext capacity plus the sidecar header and must not be
+// changed independently of that layout.
+func (ef *EncryptedFile) Resize(newSize int64) error {
+	return errEncryptedUnsupported
+}
+
+// Shrink is not supported for the same reason as Resize.
+func (ef *EncryptedFile) Shrink() error {
+	return errEncryptedUnsupported
+}
@@ -0,0 +1,135 @@
+package mapped
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func newTestKey() []byte {
+	key := make([]byte, 32)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	return key
+}
+
+// TestEncryptedRoundTripUnalignedWrites exercises two small, unaligned
+// writes that land in the same block, i.e. the read-modify-write path. A
+// nonce derived from (fileID, blockIndex) alone would reuse the same
+// nonce for both seals of that block; this must round-trip correctly.
+func TestEncryptedRoundTripUnalignedWrites(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data.enc")
+	ef, err := OpenEncrypted(path, encryptedBlockSize, os.O_RDWR|os.O_CREATE|os.O_EXCL, newTestKey(), false, nil)
+	assert.NilError(t, err)
+	defer ef.Close()
+
+	first := []byte("0123456789")
+	second := []byte("abcdefghijklmnopqrst")
+
+	n, err := ef.Write(first)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(first))
+
+	n, err = ef.Write(second)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(second))
+
+	want := append(append([]byte{}, first...), second...)
+	got := make([]byte, len(want))
+	n, err = ef.Read(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(want))
+	assert.Equal(t, string(got), string(want))
+}
+
+// TestEncryptedTamperDetection flips a ciphertext byte and checks Read
+// reports ErrAuthFailed instead of returning corrupted plaintext.
+func TestEncryptedTamperDetection(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted-tamper")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data.enc")
+	ef, err := OpenEncrypted(path, encryptedBlockSize, os.O_RDWR|os.O_CREATE|os.O_EXCL, newTestKey(), false, nil)
+	assert.NilError(t, err)
+	defer ef.Close()
+
+	_, err = ef.Write([]byte("secret"))
+	assert.NilError(t, err)
+
+	// Tamper via ef.file, not ef.MappedBytes(): the non-wmm mmap is mapped
+	// without PROT_WRITE (see decryptBlock/encryptBlock), so writing into
+	// it directly segfaults instead of reproducing real corruption.
+	tamperOffset := ef.blockDataOffset(0)
+	b := make([]byte, 1)
+	_, err = ef.file.ReadAt(b, tamperOffset)
+	assert.NilError(t, err)
+	b[0] ^= 0xff
+	_, err = ef.file.WriteAt(b, tamperOffset)
+	assert.NilError(t, err)
+
+	_, err = ef.Read(0, make([]byte, 6))
+	assert.Equal(t, err, ErrAuthFailed)
+}
+
+// TestEncryptedFileIDNotDerivedFromName checks that the fileID used in the
+// AEAD nonce is a random per-file value rather than a deterministic
+// function of fileName: two distinct files opened under the same key must
+// not end up with the same fileID, which would otherwise reproduce the
+// same (fileID, version) nonce for both files' first seals.
+func TestEncryptedFileIDNotDerivedFromName(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted-fileid")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	key := newTestKey()
+
+	ef1, err := OpenEncrypted(filepath.Join(dir, "a"), encryptedBlockSize, os.O_RDWR|os.O_CREATE|os.O_EXCL, key, false, nil)
+	assert.NilError(t, err)
+	defer ef1.Close()
+
+	ef2, err := OpenEncrypted(filepath.Join(dir, "b"), encryptedBlockSize, os.O_RDWR|os.O_CREATE|os.O_EXCL, key, false, nil)
+	assert.NilError(t, err)
+	defer ef2.Close()
+
+	assert.Assert(t, ef1.fileID != ef2.fileID)
+}
+
+// TestEncryptedFileIDPersistsAcrossReopen checks that reopening an existing
+// encrypted file recovers the same fileID rather than drawing a fresh one,
+// so already-sealed blocks keep decrypting under the nonce they were
+// sealed with.
+func TestEncryptedFileIDPersistsAcrossReopen(t *testing.T) {
+	dir, err := ioutil.TempDir("", "encrypted-fileid-reopen")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "data.enc")
+	key := newTestKey()
+
+	ef, err := OpenEncrypted(path, encryptedBlockSize, os.O_RDWR|os.O_CREATE|os.O_EXCL, key, false, nil)
+	assert.NilError(t, err)
+	_, err = ef.Write([]byte("secret"))
+	assert.NilError(t, err)
+	wantID := ef.fileID
+	assert.NilError(t, ef.Close())
+
+	reopened, err := OpenEncrypted(path, encryptedBlockSize, os.O_RDWR, key, false, nil)
+	assert.NilError(t, err)
+	defer reopened.Close()
+
+	assert.Equal(t, reopened.fileID, wantID)
+
+	got := make([]byte, 6)
+	_, err = reopened.Read(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), "secret")
+}
@@ -13,6 +13,7 @@ import (
 
 	"github.com/yrpc/util"
 	"github.com/yrpc/util/logger"
+	"github.com/yrpc/util/mapped/pagecache"
 	"go.uber.org/zap"
 )
 
@@ -37,6 +38,11 @@ type fileInterface interface {
 	Close() (err error)
 	Remove() error
 	MappedBytes() []byte
+	PunchHole(offset, length int64) error
+	Zero(offset, length int64) error
+	Allocate(offset, length int64) error
+	SeekData(offset int64) (int64, error)
+	SeekHole(offset int64) (int64, error)
 }
 
 var _ fileInterface = (*File)(nil)
@@ -61,6 +67,8 @@ type File struct {
 	file     *os.File
 	flags    int
 	wmm      bool
+
+	cache *pagecache.Cache
 }
 
 // OpenFile opens a mmaped file
@@ -97,13 +105,27 @@ func (f *File) Flags() int {
 }
 
 var (
-	errPoolForReadonly = errors.New("pool for readonly file")
+	errPoolForReadonly    = errors.New("pool for readonly file")
+	errCacheForWritable   = errors.New("cache for writable file")
+	errWriteAtForBuffered = errors.New("WriteAt for buffered file")
 	// ErrWriteBeyond when write beyond
 	ErrWriteBeyond = errors.New("write beyond")
 	// ErrReadBeyond when read beyond
 	ErrReadBeyond = errors.New("read beyond")
 )
 
+// AttachCache attaches a shared page cache to a read-only File. Once
+// attached, Read/ReadRLocked consult the cache before copying out of the
+// mmap region, so hot pages stay resident and can be shared across File
+// instances opened against the same underlying file.
+func (f *File) AttachCache(c *pagecache.Cache) error {
+	if f.flags&(os.O_RDWR|os.O_WRONLY) != 0 {
+		return errCacheForWritable
+	}
+	f.cache = c
+	return nil
+}
+
 // init仅在构造函数中调用，所以不需要考虑并发
 func (f *File) init() (err error) {
 
@@ -235,6 +257,21 @@ func (f *File) addAndGetWrotePosition(n int64) (new int64) {
 	return
 }
 
+// bumpWrotePosition advances wrotePosition to end if it is still behind,
+// racing concurrent WriteAt callers via CAS so that two overlapping writes
+// never clobber each other's advance.
+func (f *File) bumpWrotePosition(end int64) {
+	for {
+		cur := atomic.LoadInt64(&f.wrotePosition)
+		if end <= cur {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&f.wrotePosition, cur, end) {
+			return
+		}
+	}
+}
+
 func (f *File) getReadPosition() int64 {
 	if f.writeBuffer != nil {
 		return f.getCommitPosition()
@@ -263,7 +300,41 @@ func (f *File) Write(data []byte) (n int, err error) {
 	return
 }
 
-// WriteBuffers for writev
+// WriteAt writes data at an explicit offset, bypassing the sequential
+// wrotePosition cursor used by Write. It is the basis for the io.WriterAt
+// handles returned by NewWriter. If the write extends past the current
+// wrotePosition, wrotePosition is advanced to match.
+//
+// WriteAt is rejected on a buffered File (one opened with a non-nil pool):
+// it writes straight to fmap/the file, so it cannot be reconciled with
+// Commit/DoneWrite flushing from commitPosition on the assumption that
+// every write went through doWrite and the write buffer.
+func (f *File) WriteAt(data []byte, offset int64) (n int, err error) {
+	if f.writeBuffer != nil {
+		err = errWriteAtForBuffered
+		return
+	}
+
+	if offset+int64(len(data)) > f.fileSize {
+		err = ErrWriteBeyond
+		return
+	}
+
+	if f.wmm {
+		copy(f.fmap[offset:], data)
+		n = len(data)
+	} else {
+		n, err = f.file.WriteAt(data, offset)
+	}
+
+	f.bumpWrotePosition(offset + int64(n))
+
+	return
+}
+
+// WriteBuffers for writev. In the non-wmm, unbuffered file mode this
+// issues a single pwritev2(2) syscall instead of looping copy/Write per
+// buffer.
 func (f *File) WriteBuffers(buffs *net.Buffers) (n int64, err error) {
 	total := 0
 	for _, buf := range *buffs {
@@ -297,9 +368,76 @@ func (f *File) WriteBuffers(buffs *net.Buffers) (n int64, err error) {
 		return
 	}
 
-	// 写文件
-	n, err = buffs.WriteTo(f.file)
+	// 写文件：单次 pwritev2，取代逐个 buffer 的 copy/Write 循环
+	bufs := make([][]byte, len(*buffs))
+	copy(bufs, *buffs)
+
+	var got int
+	got, err = util.Pwritev(f.file, bufs, f.wrotePosition)
+	n = int64(got)
 	f.addAndGetWrotePosition(n)
+	// Only drop the bytes actually confirmed written: on a short write or
+	// an error, the caller needs the rest of *buffs left intact to retry.
+	dropWritten(buffs, n)
+
+	return
+}
+
+// dropWritten removes the first n written bytes from buffs in place,
+// mirroring net.Buffers.WriteTo's partial-write bookkeeping: fully
+// written buffers are dropped and a partially written one is re-sliced to
+// its unwritten tail.
+func dropWritten(buffs *net.Buffers, n int64) {
+	bs := *buffs
+	for n > 0 && len(bs) > 0 {
+		if int64(len(bs[0])) <= n {
+			n -= int64(len(bs[0]))
+			bs = bs[1:]
+			continue
+		}
+		bs[0] = bs[0][n:]
+		n = 0
+	}
+	*buffs = bs
+}
+
+// ReadAtv reads into bufs starting at offset with a single preadv2(2)
+// syscall in the non-wmm file mode; in wmm mode it copies out of the mmap
+// region directly, since there is no file descriptor I/O to batch.
+func (f *File) ReadAtv(offset int64, bufs [][]byte) (n int64, err error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if !f.wmm {
+		var got int
+		got, err = util.Preadv(f.file, bufs, offset)
+		n = int64(got)
+		return
+	}
+
+	readPosition := f.getReadPosition()
+	for _, buf := range bufs {
+		// offset == readPosition means there is nothing left to read, not an
+		// error per se, but there is no data to hand back either; the rest
+		// of this loop would slice one past the end of fmap.
+		if offset > readPosition || (offset == readPosition && len(buf) > 0) {
+			err = ErrReadBeyond
+			return
+		}
+
+		readTo := offset + int64(len(buf)) - 1
+		if readTo > readPosition-1 {
+			readTo = readPosition - 1
+		}
+		copy(buf, f.fmap[offset:readTo+1])
+
+		got := readTo - offset + 1
+		n += got
+		offset += got
+		if got < int64(len(buf)) {
+			break
+		}
+	}
 
 	return
 }
@@ -348,11 +486,12 @@ func (f *File) commitLocked() (commitOffset int64) {
 	}
 
 	util.TryUntilSuccess(func() bool {
-		_, err := f.writeBuffer.WriteTo(f.file)
+		_, err := util.Pwritev(f.file, [][]byte{f.writeBuffer.Bytes()}, f.commitPosition)
 		if err != nil {
-			logger.Instance().Error("Commit WriteTo", zap.Error(err))
+			logger.Instance().Error("Commit Pwritev", zap.Error(err))
 			return false
 		}
+		f.writeBuffer.Reset()
 		return true
 	}, time.Second)
 
@@ -428,17 +567,58 @@ func (f *File) Read(offset int64, data []byte) (int, error) {
 // ReadRLocked when already holding the lock
 func (f *File) ReadRLocked(offset int64, data []byte) (n int, err error) {
 	readPosition := f.getReadPosition()
-	if offset > readPosition {
-		err = ErrReadBeyond
+	if offset >= readPosition {
+		// offset == readPosition means there is nothing left to read, not
+		// an error per se, but there is no data to hand back either; the
+		// rest of this function would slice one past the end of fmap.
+		if offset > readPosition || len(data) > 0 {
+			err = ErrReadBeyond
+		}
 		return
 	}
 
 	readTo := offset + int64(len(data)) - 1
-	if readTo > readPosition {
-		readTo = readPosition
+	if readTo > readPosition-1 {
+		readTo = readPosition - 1
+	}
+
+	if f.cache == nil {
+		copy(data, f.fmap[offset:readTo+1])
+		n = int(readTo - offset + 1)
+		return
+	}
+
+	n, err = f.readCached(offset, data[:readTo-offset+1])
+	return
+}
+
+// readCached serves data out of the attached page cache, populating pages
+// from the mmap region on a miss.
+func (f *File) readCached(offset int64, data []byte) (n int, err error) {
+	pageSize := f.cache.PageSize()
+
+	for n < len(data) {
+		pos := offset + int64(n)
+		pageOffset := pos - pos%pageSize
+
+		var page *pagecache.Page
+		page, err = f.cache.Fetch(f.fileName, pageOffset, func(buf []byte) error {
+			end := pageOffset + int64(len(buf))
+			if end > int64(len(f.fmap)) {
+				end = int64(len(f.fmap))
+			}
+			copy(buf, f.fmap[pageOffset:end])
+			return nil
+		})
+		if err != nil {
+			return
+		}
+
+		inPage := pos - pageOffset
+		c := copy(data[n:], page.Data()[inPage:])
+		page.Release()
+		n += c
 	}
-	copy(data, f.fmap[offset:readTo+1])
-	n = int(readTo - offset + 1)
 
 	return
 }
@@ -0,0 +1,30 @@
+package mapped
+
+// zeroMapped memsets [offset, offset+length) of the mmap region. It is the
+// portable fallback used when the platform fallocate(2) path is
+// unavailable, and is also called after a successful Linux PunchHole/Zero
+// in wmm mode. In non-wmm mode the mapping is not writable, so the zeroes
+// are written through the file instead.
+func (f *File) zeroMapped(offset, length int64) error {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	end := offset + length
+	if end > int64(len(f.fmap)) {
+		end = int64(len(f.fmap))
+	}
+	if offset >= end {
+		return nil
+	}
+
+	if !f.wmm {
+		_, err := f.file.WriteAt(make([]byte, end-offset), offset)
+		return err
+	}
+
+	region := f.fmap[offset:end]
+	for i := range region {
+		region[i] = 0
+	}
+	return nil
+}
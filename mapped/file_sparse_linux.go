@@ -0,0 +1,79 @@
+// +build linux
+
+package mapped
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// PunchHole deallocates disk space for [offset, offset+length) without
+// changing the file's apparent size, so the tail need not be truncated to
+// reclaim it. It maps to fallocate(2) with
+// FALLOC_FL_PUNCH_HOLE|FALLOC_FL_KEEP_SIZE. Only in wmm mode is the mapped
+// region also zeroed by hand; the non-wmm mapping is read-only.
+func (f *File) PunchHole(offset, length int64) error {
+	if err := unix.Fallocate(int(f.file.Fd()), unix.FALLOC_FL_PUNCH_HOLE|unix.FALLOC_FL_KEEP_SIZE, offset, length); err != nil {
+		return err
+	}
+	if !f.wmm {
+		return nil
+	}
+	return f.zeroMapped(offset, length)
+}
+
+// Zero writes zeroes to [offset, offset+length) via fallocate(2)
+// FALLOC_FL_ZERO_RANGE, zeroing the mapped region by hand in wmm mode as
+// PunchHole does.
+func (f *File) Zero(offset, length int64) error {
+	if err := unix.Fallocate(int(f.file.Fd()), unix.FALLOC_FL_ZERO_RANGE, offset, length); err != nil {
+		return err
+	}
+	if !f.wmm {
+		return nil
+	}
+	return f.zeroMapped(offset, length)
+}
+
+// Allocate pre-commits disk space for [offset, offset+length) via
+// fallocate(2), without the KEEP_SIZE/ZERO_RANGE flags.
+func (f *File) Allocate(offset, length int64) error {
+	return unix.Fallocate(int(f.file.Fd()), 0, offset, length)
+}
+
+// seekData/seekHole are lseek(2) whence values for SEEK_DATA/SEEK_HOLE.
+// They are ABI-stable on Linux but the module's pinned golang.org/x/sys
+// (v0.0.0-20210124154548-22da62e12c0c) does not define unix.SEEK_DATA/
+// unix.SEEK_HOLE, so they are spelled out here the same way the fallocate
+// flags above come from unix where available.
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// SeekData returns the offset of the next data region at or after offset,
+// via lseek(2) SEEK_DATA. The probe runs on a separate fd opened on the
+// same path, not f.file's fd: in the non-wmm write mode, Write/doWrite
+// write via f.file.Write, which relies on the kernel-tracked current
+// offset of that exact fd staying in sync with wrotePosition. Seeking
+// f.file's fd here would desync the two.
+func (f *File) SeekData(offset int64) (int64, error) {
+	return f.seekProbe(offset, seekData)
+}
+
+// SeekHole returns the offset of the next hole at or after offset, via
+// lseek(2) SEEK_HOLE. See SeekData for why it uses its own fd.
+func (f *File) SeekHole(offset int64) (int64, error) {
+	return f.seekProbe(offset, seekHole)
+}
+
+func (f *File) seekProbe(offset int64, whence int) (int64, error) {
+	probe, err := os.Open(f.file.Name())
+	if err != nil {
+		return 0, err
+	}
+	defer probe.Close()
+
+	return unix.Seek(int(probe.Fd()), offset, whence)
+}
@@ -0,0 +1,72 @@
+// +build linux
+
+package mapped
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// TestPunchHoleZeroesRegion checks that PunchHole deallocates and zeroes
+// the requested range without touching the rest of the file.
+func TestPunchHoleZeroesRegion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparse-punch")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 32, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	_, err = f.Write(bytes.Repeat([]byte{0xab}, 32))
+	assert.NilError(t, err)
+
+	assert.NilError(t, f.PunchHole(8, 8))
+
+	got := make([]byte, 32)
+	_, err = f.Read(0, got)
+	assert.NilError(t, err)
+
+	want := bytes.Repeat([]byte{0xab}, 32)
+	for i := 8; i < 16; i++ {
+		want[i] = 0
+	}
+	assert.Equal(t, string(got), string(want))
+}
+
+// TestSeekProbeDoesNotDesyncSequentialWrite guards against the regression
+// this package already fixed once: SeekData/SeekHole must run on their own
+// fd, not f.file's, or a subsequent sequential Write (which relies on
+// f.file's kernel-tracked offset matching wrotePosition) lands in the
+// wrong place.
+func TestSeekProbeDoesNotDesyncSequentialWrite(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sparse-seek")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 32, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	first := bytes.Repeat([]byte{0xaa}, 16)
+	_, err = f.Write(first)
+	assert.NilError(t, err)
+
+	_, _ = f.SeekData(0)
+	_, _ = f.SeekHole(0)
+
+	second := bytes.Repeat([]byte{0xbb}, 16)
+	_, err = f.Write(second)
+	assert.NilError(t, err)
+
+	got := make([]byte, 32)
+	_, err = f.Read(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, string(got[:16]), string(first))
+	assert.Equal(t, string(got[16:]), string(second))
+}
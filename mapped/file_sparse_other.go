@@ -0,0 +1,35 @@
+// +build !linux
+
+package mapped
+
+import "errors"
+
+var errSparseNotSupported = errors.New("sparse file operations not supported on this platform")
+
+// PunchHole falls back to zeroing the mapped region; no disk space is
+// actually reclaimed on platforms without fallocate(2) punch-hole support.
+func (f *File) PunchHole(offset, length int64) error {
+	return f.zeroMapped(offset, length)
+}
+
+// Zero falls back to zeroing the mapped region directly.
+func (f *File) Zero(offset, length int64) error {
+	return f.zeroMapped(offset, length)
+}
+
+// Allocate is a no-op: the file was already truncated to its full size
+// when it was created (or by Resize), so there is nothing further to
+// pre-commit.
+func (f *File) Allocate(offset, length int64) error {
+	return nil
+}
+
+// SeekData is unsupported on this platform.
+func (f *File) SeekData(offset int64) (int64, error) {
+	return 0, errSparseNotSupported
+}
+
+// SeekHole is unsupported on this platform.
+func (f *File) SeekHole(offset int64) (int64, error) {
+	return 0, errSparseNotSupported
+}
@@ -0,0 +1,153 @@
+package mapped
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// TestDropWritten checks the partial-write bookkeeping WriteBuffers relies
+// on: only fully written buffers are dropped, and a partially written one
+// is re-sliced to its unwritten tail.
+func TestDropWritten(t *testing.T) {
+	buffs := net.Buffers{[]byte("abc"), []byte("defg"), []byte("hi")}
+	dropWritten(&buffs, 5) // consumes "abc" and "de"
+
+	assert.Equal(t, len(buffs), 2)
+	assert.Equal(t, string(buffs[0]), "fg")
+	assert.Equal(t, string(buffs[1]), "hi")
+}
+
+// TestFileWriteBuffersRoundTrip exercises WriteBuffers' normal success
+// path: every buffer written and *buffs left empty.
+func TestFileWriteBuffersRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writebuffers")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 32, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	buffs := net.Buffers{[]byte("hello "), []byte("world!!!")}
+	n, err := f.WriteBuffers(&buffs)
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(14))
+	assert.Equal(t, len(buffs), 0)
+
+	got := make([]byte, 14)
+	_, err = f.Read(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), "hello world!!!")
+}
+
+// TestFileWriteBuffersBeyondLeavesBuffsIntact checks that a write rejected
+// as ErrWriteBeyond (nothing was written) does not touch *buffs at all.
+func TestFileWriteBuffersBeyondLeavesBuffsIntact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writebuffers-beyond")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 4, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	buffs := net.Buffers{[]byte("way too long")}
+	n, err := f.WriteBuffers(&buffs)
+	assert.Equal(t, err, ErrWriteBeyond)
+	assert.Equal(t, n, int64(0))
+	assert.Equal(t, len(buffs), 1)
+	assert.Equal(t, string(buffs[0]), "way too long")
+}
+
+// TestWriteAtConcurrentDisjointRegionsAdvancesWrotePosition has many
+// goroutines WriteAt disjoint regions concurrently, racing to advance
+// wrotePosition. It must end up exactly at the file size, not short (which
+// would wrongly make ReadRLocked/Reader.Read treat already-written data as
+// beyond wrotePosition) regardless of store order.
+func TestWriteAtConcurrentDisjointRegionsAdvancesWrotePosition(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writeat-concurrent")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	const regionSize = 16
+	const regions = 64
+
+	f, err := CreateFile(filepath.Join(dir, "data"), regionSize*regions, true, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	errs := make([]error, regions)
+	var wg sync.WaitGroup
+	for i := 0; i < regions; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			data := make([]byte, regionSize)
+			for j := range data {
+				data[j] = byte(i)
+			}
+			_, errs[i] = f.WriteAt(data, int64(i*regionSize))
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NilError(t, err)
+	}
+	assert.Equal(t, f.GetWrotePosition(), int64(regionSize*regions))
+}
+
+// TestReadOversizedBufferAtExactlyFullFile reproduces a panic that used to
+// hit any reader handing a buffer larger than the remaining data to a File
+// that is exactly full (readPosition == len(fmap), the normal state right
+// after a segment/file fills up): the old clamp let readTo+1 equal
+// len(fmap)+1, slicing one past the end of the mmap.
+func TestReadOversizedBufferAtExactlyFullFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "read-oversized-full")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 16, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	data := []byte("0123456789abcdef")
+	_, err = f.Write(data)
+	assert.NilError(t, err)
+
+	got := make([]byte, len(data)+1)
+	n, err := f.Read(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(data))
+	assert.Equal(t, string(got[:n]), string(data))
+}
+
+// TestReadAtvOversizedBufferAtExactlyFullFile is ReadAtv's counterpart to
+// TestReadOversizedBufferAtExactlyFullFile: ReadAtv's wmm-mode loop had the
+// identical off-by-one clamp, only reachable with wmm=true since that's the
+// only mode where it reads out of fmap instead of calling Preadv.
+func TestReadAtvOversizedBufferAtExactlyFullFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "readatv-oversized-full")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 16, true, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	data := []byte("0123456789abcdef")
+	_, err = f.Write(data)
+	assert.NilError(t, err)
+
+	got := make([]byte, len(data)+1)
+	n, err := f.ReadAtv(0, [][]byte{got})
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(len(data)))
+	assert.Equal(t, string(got[:n]), string(data))
+}
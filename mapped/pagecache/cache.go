@@ -0,0 +1,262 @@
+// Package pagecache implements a bounded, shared page cache that mapped.File
+// instances can attach to. Pages are keyed by (fileName, pageOffset) so that
+// hot regions stay resident across rapid File open/close cycles and can be
+// shared between several File instances backed by the same underlying file.
+package pagecache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/yrpc/util"
+)
+
+// DefaultPageSize is used when Options.PageSize is left at zero.
+const DefaultPageSize = 64 * 1024
+
+// Key identifies a cached page by file name and page-aligned offset.
+type Key struct {
+	FileName   string
+	PageOffset int64
+}
+
+// Policy decides admission and eviction order for the Cache. New uses
+// NewTwoQueue when Options.Policy is nil, which resists the
+// scan-vulnerability of plain LRU; callers wanting a simpler policy, or
+// TinyLFU-style admission, can pass NewLRU() or implement Policy themselves.
+type Policy interface {
+	// Touch records an access to an already cached key.
+	Touch(key Key)
+	// Add records key as newly inserted.
+	Add(key Key)
+	// Evict returns the next key to evict, skipping any key present in skip
+	// (used by the caller to pass over candidates that are currently pinned),
+	// if the policy holds any.
+	Evict(skip map[Key]struct{}) (key Key, ok bool)
+	// Remove drops key from the policy's bookkeeping.
+	Remove(key Key)
+}
+
+// Page is a ref-counted page handed out by Cache.Fetch. Callers must call
+// Release once they are done reading Data.
+type Page struct {
+	cache *Cache
+	key   Key
+	data  []byte
+	refs  int32
+}
+
+// Data returns the page bytes. Valid until the last Release.
+func (p *Page) Data() []byte {
+	return p.data
+}
+
+// Release drops a reference to the page.
+func (p *Page) Release() {
+	p.cache.release(p)
+}
+
+// Options configures a Cache.
+type Options struct {
+	// MaxBytes bounds the cache resident set. Zero means unbounded.
+	MaxBytes int64
+	// PageSize is the fixed page granularity, default DefaultPageSize.
+	PageSize int64
+	// Policy is the admission/eviction policy, default NewTwoQueue().
+	Policy Policy
+}
+
+// Cache is a bounded, shared page cache keyed by (fileName, pageOffset).
+type Cache struct {
+	mu       sync.Mutex
+	pages    map[Key]*Page
+	policy   Policy
+	pageSize int64
+	maxBytes int64
+	curBytes int64
+
+	hits      prometheus.Counter
+	misses    prometheus.Counter
+	evictions prometheus.Counter
+
+	evictC chan *Page
+	stopC  chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New builds a Cache and starts its background evictor.
+func New(opts Options) *Cache {
+	if opts.PageSize <= 0 {
+		opts.PageSize = DefaultPageSize
+	}
+	if opts.Policy == nil {
+		opts.Policy = NewTwoQueue(DefaultGhostCapacity)
+	}
+
+	c := &Cache{
+		pages:    make(map[Key]*Page),
+		policy:   opts.Policy,
+		pageSize: opts.PageSize,
+		maxBytes: opts.MaxBytes,
+		hits:      prometheus.NewCounter(prometheus.CounterOpts{Name: "mapped_pagecache_hits_total", Help: "page cache hits"}),
+		misses:    prometheus.NewCounter(prometheus.CounterOpts{Name: "mapped_pagecache_misses_total", Help: "page cache misses"}),
+		evictions: prometheus.NewCounter(prometheus.CounterOpts{Name: "mapped_pagecache_evictions_total", Help: "page cache evictions"}),
+		evictC:   make(chan *Page, 64),
+		stopC:    make(chan struct{}),
+	}
+
+	c.wg.Add(1)
+	go c.evictLoop()
+
+	return c
+}
+
+// Describe implements prometheus.Collector.
+func (c *Cache) Describe(ch chan<- *prometheus.Desc) {
+	c.hits.Describe(ch)
+	c.misses.Describe(ch)
+	c.evictions.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (c *Cache) Collect(ch chan<- prometheus.Metric) {
+	c.hits.Collect(ch)
+	c.misses.Collect(ch)
+	c.evictions.Collect(ch)
+}
+
+// PageSize returns the cache's fixed page size.
+func (c *Cache) PageSize() int64 {
+	return c.pageSize
+}
+
+// Fetch returns the page covering pageOffset (which must be page-aligned),
+// populating it via fill on a miss. The returned Page must be Released.
+func (c *Cache) Fetch(fileName string, pageOffset int64, fill func(buf []byte) error) (*Page, error) {
+	key := Key{FileName: fileName, PageOffset: pageOffset}
+
+	c.mu.Lock()
+	if p, ok := c.pages[key]; ok {
+		atomic.AddInt32(&p.refs, 1)
+		c.policy.Touch(key)
+		c.mu.Unlock()
+		c.hits.Inc()
+		return p, nil
+	}
+	c.mu.Unlock()
+
+	c.misses.Inc()
+
+	buf := make([]byte, c.pageSize)
+	if err := fill(buf); err != nil {
+		return nil, err
+	}
+	// Best-effort: pin the page resident so the MUnlock the evictor issues
+	// on eviction is meaningful. A failure (e.g. RLIMIT_MEMLOCK) is not
+	// fatal to serving the page, just to keeping it off swap.
+	_ = util.MLock(buf, len(buf))
+
+	p := &Page{cache: c, key: key, data: buf, refs: 1}
+
+	c.mu.Lock()
+	if existing, ok := c.pages[key]; ok {
+		// lost the race against a concurrent Fetch: our buf never entered
+		// c.pages, so it won't be MUnlock'd by eviction either. Undo the
+		// MLock above ourselves before discarding it.
+		atomic.AddInt32(&existing.refs, 1)
+		c.policy.Touch(key)
+		c.mu.Unlock()
+		util.MUnlock(buf, len(buf))
+		return existing, nil
+	}
+	c.pages[key] = p
+	c.policy.Add(key)
+	c.curBytes += int64(len(buf))
+	c.mu.Unlock()
+
+	c.evictIfNeeded()
+
+	return p, nil
+}
+
+func (c *Cache) release(p *Page) {
+	atomic.AddInt32(&p.refs, -1)
+}
+
+// evictIfNeeded drops pages in the policy's eviction order until the cache
+// is back under MaxBytes. A candidate still referenced by a caller is passed over
+// in favor of the next-oldest one, rather than stalling the whole pass;
+// pinned keys are simply revisited on the next call once released.
+func (c *Cache) evictIfNeeded() {
+	if c.maxBytes <= 0 {
+		return
+	}
+
+	var pinned map[Key]struct{}
+
+	for {
+		c.mu.Lock()
+		if c.curBytes <= c.maxBytes {
+			c.mu.Unlock()
+			return
+		}
+
+		key, ok := c.policy.Evict(pinned)
+		if !ok {
+			c.mu.Unlock()
+			return
+		}
+
+		p := c.pages[key]
+		if p == nil {
+			// already gone from the cache, but the policy still had it:
+			// drop the stale entry and keep scanning.
+			c.policy.Remove(key)
+			c.mu.Unlock()
+			continue
+		}
+		if atomic.LoadInt32(&p.refs) > 0 {
+			// still in use: remember it and try the next-oldest candidate
+			if pinned == nil {
+				pinned = make(map[Key]struct{})
+			}
+			pinned[key] = struct{}{}
+			c.mu.Unlock()
+			continue
+		}
+
+		delete(c.pages, key)
+		c.policy.Remove(key)
+		c.curBytes -= int64(len(p.data))
+		c.mu.Unlock()
+
+		c.evictions.Inc()
+		select {
+		case c.evictC <- p:
+		default:
+			// evictor is behind, unlock inline instead of blocking callers
+			util.MUnlock(p.data, len(p.data))
+		}
+	}
+}
+
+// evictLoop calls MUnlock on pages that fell out of the cache.
+func (c *Cache) evictLoop() {
+	defer c.wg.Done()
+	for {
+		select {
+		case p := <-c.evictC:
+			util.MUnlock(p.data, len(p.data))
+		case <-c.stopC:
+			return
+		}
+	}
+}
+
+// Close stops the background evictor. It does not release any pages still
+// referenced by callers.
+func (c *Cache) Close() {
+	close(c.stopC)
+	c.wg.Wait()
+}
@@ -0,0 +1,150 @@
+package pagecache
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"gotest.tools/assert"
+)
+
+// TestCacheFetchHitAndMiss checks that a second Fetch for the same key is
+// served from the cache (no re-fill, hits counter bumped) rather than
+// calling fill again.
+func TestCacheFetchHitAndMiss(t *testing.T) {
+	c := New(Options{PageSize: 16})
+	defer c.Close()
+
+	fills := 0
+	fill := func(buf []byte) error {
+		fills++
+		for i := range buf {
+			buf[i] = byte(i)
+		}
+		return nil
+	}
+
+	p1, err := c.Fetch("f", 0, fill)
+	assert.NilError(t, err)
+	p1.Release()
+
+	p2, err := c.Fetch("f", 0, fill)
+	assert.NilError(t, err)
+	defer p2.Release()
+
+	assert.Equal(t, fills, 1)
+	assert.Equal(t, string(p2.Data()[:3]), string([]byte{0, 1, 2}))
+	assert.Equal(t, testutil.ToFloat64(c.hits), float64(1))
+	assert.Equal(t, testutil.ToFloat64(c.misses), float64(1))
+}
+
+// TestCacheEvictsOverMaxBytes checks that fetching past MaxBytes evicts the
+// oldest page, so a later re-fetch of it is a miss again. MaxBytes only
+// ever fits one PageSize-16 page resident at a time here, so both the
+// second Fetch (evicting page 0) and the re-fetch of page 0 (evicting page
+// 16 in turn) push the cache back over budget: two evictions total,
+// regardless of which policy orders them.
+func TestCacheEvictsOverMaxBytes(t *testing.T) {
+	c := New(Options{PageSize: 16, MaxBytes: 16})
+	defer c.Close()
+
+	noop := func(buf []byte) error { return nil }
+
+	p1, err := c.Fetch("f", 0, noop)
+	assert.NilError(t, err)
+	p1.Release()
+
+	// a second page pushes curBytes (32) over MaxBytes (16), evicting page 0
+	p2, err := c.Fetch("f", 16, noop)
+	assert.NilError(t, err)
+	p2.Release()
+
+	fills := 0
+	countingFill := func(buf []byte) error {
+		fills++
+		return nil
+	}
+	// page 0 was evicted above, so this re-fetch is a miss; pushing curBytes
+	// back over MaxBytes evicts page 16 in turn.
+	p3, err := c.Fetch("f", 0, countingFill)
+	assert.NilError(t, err)
+	defer p3.Release()
+
+	assert.Equal(t, fills, 1)
+	assert.Equal(t, testutil.ToFloat64(c.evictions), float64(2))
+}
+
+// TestCacheFetchConcurrentMissRace has many goroutines race a cache miss
+// for the same key. Whichever fill wins gets to populate c.pages; every
+// goroutine must get back that same winning page, including the losers
+// whose own filled buf never entered the cache (and so must be MUnlock'd
+// by Fetch itself rather than leaked).
+func TestCacheFetchConcurrentMissRace(t *testing.T) {
+	c := New(Options{PageSize: 16})
+	defer c.Close()
+
+	fill := func(buf []byte) error { return nil }
+
+	const goroutines = 32
+	pages := make([]*Page, goroutines)
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			p, err := c.Fetch("f", 0, fill)
+			assert.NilError(t, err)
+			pages[i] = p
+		}(i)
+	}
+	wg.Wait()
+	defer func() {
+		for _, p := range pages {
+			p.Release()
+		}
+	}()
+
+	for _, p := range pages {
+		assert.Assert(t, p == pages[0])
+	}
+}
+
+// TestCacheEvictsPastPinnedPage checks that a page held by a caller (refs >
+// 0) is skipped in favor of the next-oldest evictable page, instead of
+// stalling eviction entirely until the pinned page is released.
+func TestCacheEvictsPastPinnedPage(t *testing.T) {
+	c := New(Options{PageSize: 16, MaxBytes: 16})
+	defer c.Close()
+
+	noop := func(buf []byte) error { return nil }
+
+	p1, err := c.Fetch("f", 0, noop)
+	assert.NilError(t, err)
+	defer p1.Release() // held: must not be evicted
+
+	p2, err := c.Fetch("f", 16, noop)
+	assert.NilError(t, err)
+	p2.Release()
+
+	// a third page pushes curBytes (48) over MaxBytes (16); page 0 is pinned,
+	// so page 16 (the next-oldest unpinned candidate) must be evicted instead.
+	p3, err := c.Fetch("f", 32, noop)
+	assert.NilError(t, err)
+	defer p3.Release()
+
+	fills := 0
+	countingFill := func(buf []byte) error {
+		fills++
+		return nil
+	}
+
+	r1, err := c.Fetch("f", 0, countingFill)
+	assert.NilError(t, err)
+	defer r1.Release()
+	assert.Equal(t, fills, 0, "pinned page must not have been evicted")
+
+	r2, err := c.Fetch("f", 16, countingFill)
+	assert.NilError(t, err)
+	defer r2.Release()
+	assert.Equal(t, fills, 1, "unpinned page must have been evicted")
+}
@@ -0,0 +1,54 @@
+package pagecache
+
+import "container/list"
+
+// LRU is a Policy with plain least-recently-used eviction. It is simpler
+// than the default NewTwoQueue policy and does not resist large scans.
+type LRU struct {
+	ll    *list.List
+	elems map[Key]*list.Element
+}
+
+// NewLRU builds an empty LRU policy.
+func NewLRU() *LRU {
+	return &LRU{
+		ll:    list.New(),
+		elems: make(map[Key]*list.Element),
+	}
+}
+
+// Touch moves key to the front (most recently used).
+func (p *LRU) Touch(key Key) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.MoveToFront(e)
+	}
+}
+
+// Add inserts key as the most recently used entry.
+func (p *LRU) Add(key Key) {
+	if _, ok := p.elems[key]; ok {
+		p.Touch(key)
+		return
+	}
+	p.elems[key] = p.ll.PushFront(key)
+}
+
+// Evict returns the least recently used key not present in skip, if any.
+func (p *LRU) Evict(skip map[Key]struct{}) (key Key, ok bool) {
+	for e := p.ll.Back(); e != nil; e = e.Prev() {
+		k := e.Value.(Key)
+		if _, skipped := skip[k]; skipped {
+			continue
+		}
+		return k, true
+	}
+	return
+}
+
+// Remove drops key from the policy's bookkeeping.
+func (p *LRU) Remove(key Key) {
+	if e, ok := p.elems[key]; ok {
+		p.ll.Remove(e)
+		delete(p.elems, key)
+	}
+}
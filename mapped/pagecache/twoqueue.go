@@ -0,0 +1,123 @@
+package pagecache
+
+import "container/list"
+
+// DefaultGhostCapacity bounds the A1out ghost queue of NewTwoQueue when
+// callers don't size it explicitly. Ghost entries are keys only, so this
+// is cheap relative to PageSize.
+const DefaultGhostCapacity = 1024
+
+// TwoQueue is a 2-Q admission policy (Johnson & Shasha): pages seen once
+// go into a FIFO (A1in) instead of straight into the LRU (Am), so a single
+// large sequential scan cannot evict the working set the way plain LRU
+// would. A page promotes to Am only on a second access. Keys evicted from
+// A1in are remembered for a while in a ghost FIFO (A1out) so that a key
+// seen again shortly after being evicted from A1in is promoted directly to
+// Am instead of restarting in A1in.
+type TwoQueue struct {
+	am    *list.List
+	amMap map[Key]*list.Element
+
+	a1in    *list.List
+	a1inMap map[Key]*list.Element
+
+	a1out    *list.List
+	a1outMap map[Key]*list.Element
+	a1outCap int
+}
+
+// NewTwoQueue builds an empty 2-Q policy. ghostCapacity bounds the A1out
+// ghost queue; DefaultGhostCapacity is a reasonable default.
+func NewTwoQueue(ghostCapacity int) *TwoQueue {
+	return &TwoQueue{
+		am:       list.New(),
+		amMap:    make(map[Key]*list.Element),
+		a1in:     list.New(),
+		a1inMap:  make(map[Key]*list.Element),
+		a1out:    list.New(),
+		a1outMap: make(map[Key]*list.Element),
+		a1outCap: ghostCapacity,
+	}
+}
+
+// Touch records a repeat access to an already cached key: Am entries move
+// to the front, and an A1in entry that is hit again is promoted to Am.
+func (p *TwoQueue) Touch(key Key) {
+	if e, ok := p.amMap[key]; ok {
+		p.am.MoveToFront(e)
+		return
+	}
+
+	if e, ok := p.a1inMap[key]; ok {
+		p.a1in.Remove(e)
+		delete(p.a1inMap, key)
+		p.amMap[key] = p.am.PushFront(key)
+	}
+}
+
+// Add records key as newly inserted: a key still remembered in the A1out
+// ghost list is promoted straight to Am (it was hot enough to be re-admitted
+// shortly after eviction); anything else starts in A1in.
+func (p *TwoQueue) Add(key Key) {
+	if e, ok := p.a1outMap[key]; ok {
+		p.a1out.Remove(e)
+		delete(p.a1outMap, key)
+		p.amMap[key] = p.am.PushFront(key)
+		return
+	}
+
+	p.a1inMap[key] = p.a1in.PushFront(key)
+}
+
+// Evict returns the next key to drop, skipping any key present in skip:
+// A1in is drained before Am, matching the standard 2-Q eviction order.
+func (p *TwoQueue) Evict(skip map[Key]struct{}) (key Key, ok bool) {
+	for e := p.a1in.Back(); e != nil; e = e.Prev() {
+		k := e.Value.(Key)
+		if _, skipped := skip[k]; skipped {
+			continue
+		}
+		return k, true
+	}
+	for e := p.am.Back(); e != nil; e = e.Prev() {
+		k := e.Value.(Key)
+		if _, skipped := skip[k]; skipped {
+			continue
+		}
+		return k, true
+	}
+	return
+}
+
+// Remove drops key from whichever queue holds it. A key removed from A1in
+// is pushed onto the A1out ghost queue so a near-term re-access still gets
+// promoted to Am.
+func (p *TwoQueue) Remove(key Key) {
+	if e, ok := p.a1inMap[key]; ok {
+		p.a1in.Remove(e)
+		delete(p.a1inMap, key)
+		p.pushGhost(key)
+		return
+	}
+
+	if e, ok := p.amMap[key]; ok {
+		p.am.Remove(e)
+		delete(p.amMap, key)
+	}
+}
+
+func (p *TwoQueue) pushGhost(key Key) {
+	if p.a1outCap <= 0 {
+		return
+	}
+
+	if len(p.a1outMap) >= p.a1outCap {
+		if back := p.a1out.Back(); back != nil {
+			bk := back.Value.(Key)
+			p.a1out.Remove(back)
+			delete(p.a1outMap, bk)
+		}
+	}
+
+	p.a1outMap[key] = p.a1out.PushFront(key)
+}
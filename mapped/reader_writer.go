@@ -0,0 +1,117 @@
+package mapped
+
+import (
+	"errors"
+	"io"
+)
+
+var errInvalidWhence = errors.New("invalid whence")
+
+// Reader is a per-caller streaming handle over a File's mapped region. It
+// carries its own offset, advanced by Read, so several Readers can stream
+// disjoint regions of the same File concurrently without a shared cursor.
+// ReadAt bypasses the Reader's own offset entirely.
+type Reader struct {
+	f      *File
+	offset int64
+}
+
+// NewReader returns a Reader over f, positioned at offset 0.
+func (f *File) NewReader() *Reader {
+	return &Reader{f: f}
+}
+
+var (
+	_ io.Reader   = (*Reader)(nil)
+	_ io.ReaderAt = (*Reader)(nil)
+	_ io.Seeker   = (*Reader)(nil)
+)
+
+// Read implements io.Reader, advancing the Reader's offset by the number of
+// bytes read. A Reader positioned exactly at the end of written data
+// reports io.EOF itself rather than calling into File.Read, whose
+// ErrReadBeyond is meant for offsets genuinely past what has been written,
+// not the ordinary end-of-stream a caller like io.Copy/io.ReadFull expects.
+func (r *Reader) Read(p []byte) (n int, err error) {
+	if len(p) > 0 && r.offset >= r.f.getReadPosition() {
+		return 0, io.EOF
+	}
+
+	n, err = r.f.Read(r.offset, p)
+	r.offset += int64(n)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// ReadAt implements io.ReaderAt. It does not use or update r's offset.
+func (r *Reader) ReadAt(p []byte, off int64) (n int, err error) {
+	n, err = r.f.Read(off, p)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return
+}
+
+// Seek implements io.Seeker.
+func (r *Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		r.offset = offset
+	case io.SeekCurrent:
+		r.offset += offset
+	case io.SeekEnd:
+		r.offset = r.f.GetWrotePosition() + offset
+	default:
+		return 0, errInvalidWhence
+	}
+	return r.offset, nil
+}
+
+// Writer is a per-caller streaming handle over a File's mapped region,
+// mirroring Reader on the write side. Write advances the Writer's own
+// offset; WriteAt bypasses it.
+type Writer struct {
+	f      *File
+	offset int64
+}
+
+// NewWriter returns a Writer over f, positioned at offset 0.
+func (f *File) NewWriter() *Writer {
+	return &Writer{f: f}
+}
+
+var (
+	_ io.Writer   = (*Writer)(nil)
+	_ io.WriterAt = (*Writer)(nil)
+	_ io.Seeker   = (*Writer)(nil)
+)
+
+// Write implements io.Writer, advancing the Writer's offset by the number
+// of bytes written.
+func (w *Writer) Write(p []byte) (n int, err error) {
+	n, err = w.f.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return
+}
+
+// WriteAt implements io.WriterAt. It does not use or update w's offset.
+func (w *Writer) WriteAt(p []byte, off int64) (n int, err error) {
+	return w.f.WriteAt(p, off)
+}
+
+// Seek implements io.Seeker.
+func (w *Writer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		w.offset = offset
+	case io.SeekCurrent:
+		w.offset += offset
+	case io.SeekEnd:
+		w.offset = w.f.GetWrotePosition() + offset
+	default:
+		return 0, errInvalidWhence
+	}
+	return w.offset, nil
+}
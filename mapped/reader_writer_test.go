@@ -0,0 +1,64 @@
+package mapped
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+// TestReaderEOFAtEndOfData reproduces the exact pattern that used to panic:
+// reading a Reader to precisely the end of written data via io.ReadFull,
+// then reading it once more. That extra Read must report io.EOF, not index
+// past the end of the mapped region.
+func TestReaderEOFAtEndOfData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "reader-eof")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 16, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	data := []byte("0123456789abcdef")
+	_, err = f.Write(data)
+	assert.NilError(t, err)
+
+	r := f.NewReader()
+	got := make([]byte, len(data))
+	n, err := io.ReadFull(r, got)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(data))
+	assert.Equal(t, string(got), string(data))
+
+	extra := make([]byte, 1)
+	n, err = r.Read(extra)
+	assert.Equal(t, n, 0)
+	assert.Equal(t, err, io.EOF)
+}
+
+// TestWriterIOCopy exercises Writer against io.Copy, the idiomatic way a
+// caller would stream data in.
+func TestWriterIOCopy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "writer-copy")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	f, err := CreateFile(filepath.Join(dir, "data"), 16, false, nil)
+	assert.NilError(t, err)
+	defer f.Close()
+
+	data := []byte("hello world12345")[:16]
+	n, err := io.Copy(f.NewWriter(), bytes.NewReader(data))
+	assert.NilError(t, err)
+	assert.Equal(t, n, int64(16))
+
+	got := make([]byte, 16)
+	_, err = f.Read(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, string(got), string(data))
+}
@@ -0,0 +1,357 @@
+package mapped
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yrpc/util/logger"
+	"go.uber.org/zap"
+)
+
+// errInvalidSegmentSize is returned by OpenSegments for a non-positive
+// SegmentSize.
+var errInvalidSegmentSize = errors.New("invalid segment size")
+
+const segmentNamePattern = "%08d.log"
+
+func segmentPath(dir string, index int64) string {
+	return filepath.Join(dir, fmt.Sprintf(segmentNamePattern, index))
+}
+
+// SegmentsOptions configures a Segments log.
+type SegmentsOptions struct {
+	// Dir holds the segment files.
+	Dir string
+	// SegmentSize bounds each segment file.
+	SegmentSize int64
+	// Wmm and Pool are forwarded to each segment's underlying File.
+	Wmm  bool
+	Pool *sync.Pool
+
+	// MaxTotalBytes and MaxAge bound retention of old segments; zero means
+	// unbounded. The active segment is never dropped.
+	MaxTotalBytes int64
+	MaxAge        time.Duration
+
+	// SyncInterval runs a background Sync on the active segment; zero
+	// disables it.
+	SyncInterval time.Duration
+}
+
+type segment struct {
+	index int64
+	base  int64 // first logical offset served by this segment
+	file  *File
+}
+
+// Segments manages an ordered directory of fixed-size *File segments
+// (00000000.log, 00000001.log, ...) and presents a single logical
+// append-only stream: Write rolls over to a new segment once the active
+// one fills up, and ReadAt dispatches a global logical offset to the
+// segment that owns it.
+type Segments struct {
+	mu   sync.RWMutex
+	opts SegmentsOptions
+	segs []*segment // ordered by base, oldest first
+
+	stopC chan struct{}
+	wg    sync.WaitGroup
+}
+
+// OpenSegments opens an existing segmented log in opts.Dir, or starts a new
+// one if the directory has no segment files yet.
+func OpenSegments(opts SegmentsOptions) (s *Segments, err error) {
+	if opts.SegmentSize <= 0 {
+		err = errInvalidSegmentSize
+		return
+	}
+
+	entries, err := ioutil.ReadDir(opts.Dir)
+	if err != nil {
+		return
+	}
+
+	var indexes []int64
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".log") {
+			continue
+		}
+		idx, convErr := strconv.ParseInt(strings.TrimSuffix(e.Name(), ".log"), 10, 64)
+		if convErr != nil {
+			continue
+		}
+		indexes = append(indexes, idx)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	s = &Segments{opts: opts, stopC: make(chan struct{})}
+
+	base := int64(0)
+	for i, idx := range indexes {
+		var f *File
+		f, err = OpenFile(segmentPath(opts.Dir, idx), opts.SegmentSize, os.O_RDWR, opts.Wmm, opts.Pool)
+		if err != nil {
+			return
+		}
+		s.segs = append(s.segs, &segment{index: idx, base: base, file: f})
+		if i == len(indexes)-1 {
+			base += f.GetWrotePosition()
+		} else {
+			base += f.fileSize
+		}
+	}
+
+	if len(s.segs) == 0 {
+		var f *File
+		f, err = CreateFile(segmentPath(opts.Dir, 0), opts.SegmentSize, opts.Wmm, opts.Pool)
+		if err != nil {
+			return
+		}
+		s.segs = append(s.segs, &segment{index: 0, base: 0, file: f})
+	}
+
+	if opts.SyncInterval > 0 {
+		s.wg.Add(1)
+		go s.syncLoop()
+	}
+
+	return
+}
+
+func (s *Segments) active() *segment {
+	return s.segs[len(s.segs)-1]
+}
+
+// roll commits the active segment and opens the next one.
+func (s *Segments) roll() (err error) {
+	cur := s.active()
+	cur.file.DoneWrite()
+
+	next := &segment{index: cur.index + 1, base: cur.base + cur.file.fileSize}
+	next.file, err = CreateFile(segmentPath(s.opts.Dir, next.index), s.opts.SegmentSize, s.opts.Wmm, s.opts.Pool)
+	if err != nil {
+		return
+	}
+	s.segs = append(s.segs, next)
+
+	s.applyRetention()
+	return
+}
+
+// Write appends data to the logical stream, rolling to a new segment
+// whenever the active one runs out of room.
+func (s *Segments) Write(data []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(data) > 0 {
+		cur := s.active()
+		remaining := cur.file.fileSize - cur.file.GetWrotePosition()
+		if remaining <= 0 {
+			if err = s.roll(); err != nil {
+				return
+			}
+			continue
+		}
+
+		chunk := data
+		if int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+		}
+
+		var wrote int
+		wrote, err = cur.file.Write(chunk)
+		if err != nil {
+			return
+		}
+
+		n += wrote
+		data = data[wrote:]
+	}
+	return
+}
+
+// WriteBuffers writes buffs to the logical stream, rolling segments the
+// same way Write does. On a mid-loop error only the buffers (or partial
+// buffer) actually confirmed written are dropped, so a caller can retry
+// with the rest of *buffs intact.
+func (s *Segments) WriteBuffers(buffs *net.Buffers) (n int64, err error) {
+	for _, buf := range *buffs {
+		var wrote int
+		wrote, err = s.Write(buf)
+		n += int64(wrote)
+		if err != nil {
+			break
+		}
+	}
+	dropWritten(buffs, n)
+	return
+}
+
+// ReadAt reads from a global logical offset, dispatching to the segment
+// that owns it (found by binary search over segment base offsets).
+func (s *Segments) ReadAt(offset int64, data []byte) (n int, err error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// applyRetention can have dropped the oldest segment(s) since offset
+	// was captured by the caller; dispatching below would otherwise search
+	// only the remaining segments and land on index 0 for an offset before
+	// its base, calling seg.file.Read with a negative offset.
+	if len(s.segs) == 0 || offset < s.segs[0].base {
+		err = ErrReadBeyond
+		return
+	}
+
+	for len(data) > 0 {
+		idx := sort.Search(len(s.segs), func(i int) bool {
+			return s.segs[i].base+s.segs[i].file.fileSize > offset
+		})
+		if idx == len(s.segs) {
+			err = ErrReadBeyond
+			return
+		}
+		seg := s.segs[idx]
+
+		var got int
+		got, err = seg.file.Read(offset-seg.base, data)
+		if err != nil {
+			return
+		}
+		n += got
+		offset += int64(got)
+		data = data[got:]
+
+		if got == 0 {
+			return
+		}
+	}
+	return
+}
+
+// Len returns the logical length of the stream.
+func (s *Segments) Len() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	act := s.active()
+	return act.base + act.file.GetWrotePosition()
+}
+
+// Sync flushes the active segment to disk.
+func (s *Segments) Sync() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.active().file.Sync()
+}
+
+func (s *Segments) syncLoop() {
+	defer s.wg.Done()
+
+	t := time.NewTicker(s.opts.SyncInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			if err := s.Sync(); err != nil {
+				logger.Instance().Error("Segments Sync", zap.Error(err))
+			}
+		case <-s.stopC:
+			return
+		}
+	}
+}
+
+// applyRetention drops segments older than MaxAge or that push the total
+// logical size over MaxTotalBytes. The active segment is never dropped.
+// Callers must hold s.mu.
+func (s *Segments) applyRetention() {
+	if s.opts.MaxTotalBytes <= 0 && s.opts.MaxAge <= 0 {
+		return
+	}
+
+	for len(s.segs) > 1 {
+		oldest := s.segs[0]
+
+		dropByAge := false
+		if s.opts.MaxAge > 0 {
+			if t, err := oldest.file.LastModified(); err == nil && time.Since(t) > s.opts.MaxAge {
+				dropByAge = true
+			}
+		}
+
+		dropBySize := false
+		if s.opts.MaxTotalBytes > 0 {
+			last := s.segs[len(s.segs)-1]
+			total := last.base + last.file.fileSize - oldest.base
+			if total > s.opts.MaxTotalBytes {
+				dropBySize = true
+			}
+		}
+
+		if !dropByAge && !dropBySize {
+			return
+		}
+
+		oldest.file.Close()
+		oldest.file.Remove()
+		s.segs = s.segs[1:]
+	}
+}
+
+// Close stops the background syncer and closes all open segments.
+func (s *Segments) Close() (err error) {
+	close(s.stopC)
+	s.wg.Wait()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, seg := range s.segs {
+		if closeErr := seg.file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return
+}
+
+// SegmentsReader streams the logical log from a given offset, for replay.
+type SegmentsReader struct {
+	s      *Segments
+	offset int64
+}
+
+// NewReader returns a SegmentsReader positioned at logical offset 0.
+func (s *Segments) NewReader() *SegmentsReader {
+	return &SegmentsReader{s: s}
+}
+
+var _ io.Reader = (*SegmentsReader)(nil)
+
+// Read implements io.Reader, advancing the reader's logical offset. A
+// reader positioned at the end of the logical stream reports io.EOF
+// itself, matching Reader.Read, instead of surfacing ReadAt's ErrReadBeyond.
+func (r *SegmentsReader) Read(p []byte) (n int, err error) {
+	if len(p) > 0 && r.offset >= r.s.Len() {
+		return 0, io.EOF
+	}
+
+	n, err = r.s.ReadAt(r.offset, p)
+	r.offset += int64(n)
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return
+}
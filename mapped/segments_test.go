@@ -0,0 +1,53 @@
+package mapped
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"gotest.tools/assert"
+)
+
+func TestSegmentsRollover(t *testing.T) {
+	dir, err := ioutil.TempDir("", "segments")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenSegments(SegmentsOptions{Dir: dir, SegmentSize: 16})
+	assert.NilError(t, err)
+	defer s.Close()
+
+	// 20 bytes over a 16-byte SegmentSize forces a rollover mid-write.
+	data := []byte("0123456789abcdefghij")
+	n, err := s.Write(data)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(data))
+	assert.Equal(t, s.Len(), int64(len(data)))
+
+	got := make([]byte, len(data))
+	n, err = s.ReadAt(0, got)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(data))
+	assert.Equal(t, string(got), string(data))
+}
+
+func TestSegmentsReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "segments-replay")
+	assert.NilError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := OpenSegments(SegmentsOptions{Dir: dir, SegmentSize: 8})
+	assert.NilError(t, err)
+	defer s.Close()
+
+	data := []byte("hello world")
+	_, err = s.Write(data)
+	assert.NilError(t, err)
+
+	got := make([]byte, len(data))
+	n, err := io.ReadFull(s.NewReader(), got)
+	assert.NilError(t, err)
+	assert.Equal(t, n, len(data))
+	assert.Equal(t, string(got), string(data))
+}
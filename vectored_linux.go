@@ -0,0 +1,23 @@
+// +build linux
+
+package util
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Pwritev writes bufs to file at offset with a single pwritev2(2) syscall,
+// instead of looping copy/Write per buffer.
+func Pwritev(file *os.File, bufs [][]byte, offset int64) (n int, err error) {
+	n, err = unix.Pwritev2(int(file.Fd()), bufs, offset, 0)
+	return
+}
+
+// Preadv reads into bufs from file at offset with a single preadv2(2)
+// syscall.
+func Preadv(file *os.File, bufs [][]byte, offset int64) (n int, err error) {
+	n, err = unix.Preadv2(int(file.Fd()), bufs, offset, 0)
+	return
+}
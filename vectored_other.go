@@ -0,0 +1,34 @@
+// +build !linux
+
+package util
+
+import "os"
+
+// Pwritev writes bufs to file at offset. Platforms without pwritev2(2)
+// fall back to a per-buffer WriteAt loop instead of failing outright.
+func Pwritev(file *os.File, bufs [][]byte, offset int64) (n int, err error) {
+	for _, buf := range bufs {
+		var wrote int
+		wrote, err = file.WriteAt(buf, offset+int64(n))
+		n += wrote
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// Preadv reads into bufs from file at offset. Platforms without
+// preadv2(2) fall back to a per-buffer ReadAt loop instead of failing
+// outright.
+func Preadv(file *os.File, bufs [][]byte, offset int64) (n int, err error) {
+	for _, buf := range bufs {
+		var got int
+		got, err = file.ReadAt(buf, offset+int64(n))
+		n += got
+		if err != nil {
+			return
+		}
+	}
+	return
+}